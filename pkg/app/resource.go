@@ -3,8 +3,22 @@
 package app
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ResourceProvider is the interface that describes a provider for resources.
@@ -46,11 +60,80 @@ type ResourceProvider interface {
 	AdsTxt() string
 }
 
+// FingerprintedResourceProvider is implemented by resource providers that can
+// serve a static resource under a content-hashed, immutable URL, eg
+// "/web/app.3a7c91f2.wasm" instead of "/web/app.wasm". AppWASM, RobotsTxt,
+// and AdsTxt already return the hashed path when a provider implements this
+// interface, so those URLs can be cached forever. Rewriting other references
+// to a static resource, eg a manifest, a service worker precache list, or a
+// <script>/<link> tag emitted elsewhere, is the caller's responsibility.
+type FingerprintedResourceProvider interface {
+	ResourceProvider
+
+	// Fingerprint returns the content-hashed path for the static resource
+	// located at the given "/web/..." path, and reports whether the
+	// resource was found.
+	Fingerprint(path string) (fingerprinted string, ok bool)
+}
+
+// fingerprintPattern matches a static resource path rewritten with an 8
+// character content hash, eg "/web/app.3a7c91f2.wasm".
+var fingerprintPattern = regexp.MustCompile(`^(.*)\.([0-9a-f]{8})(\.[^./]+)$`)
+
+// withFingerprinting wraps h so that requests for a content-hashed path are
+// transparently rewritten to the original path before being handled, and the
+// response is marked as immutable and cacheable for a year.
+func withFingerprinting(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := fingerprintPattern.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		original := r.Clone(r.Context())
+		u := *r.URL
+		u.Path = match[1] + match[3]
+		original.URL = &u
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		h.ServeHTTP(w, original)
+	})
+}
+
+// fingerprint hashes content and inserts the hash into name as an 8
+// character hex suffix, eg "app.wasm" becomes "app.3a7c91f2.wasm".
+func fingerprint(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	return base + "." + hash + ext
+}
+
+// containsDotDot reports whether v, once split on path separators, contains a
+// ".." element. It is used to reject requests that try to escape the root of
+// a directory-backed ResourceProvider, the same way http.Dir does.
+func containsDotDot(v string) bool {
+	if !strings.Contains(v, "..") {
+		return false
+	}
+
+	for _, ent := range strings.FieldsFunc(v, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if ent == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
 // LocalDir returns a resource provider that serves static resources from a
 // local directory located at the given path.
 func LocalDir(path string) ResourceProvider {
 	return localDir{
-		Handler: http.StripPrefix("/web/", http.FileServer(http.Dir(path))),
+		Handler: withFingerprinting(http.StripPrefix("/web/", http.FileServer(http.Dir(path)))),
 		path:    path,
 	}
 }
@@ -69,50 +152,345 @@ func (d localDir) StaticResources() string {
 }
 
 func (d localDir) AppWASM() string {
-	return "/web/app.wasm"
+	return d.fingerprintedOrPath("/web/app.wasm")
 }
 
 func (d localDir) RobotsTxt() string {
-	return "/web/robots.txt"
+	return d.fingerprintedOrPath("/web/robots.txt")
 }
 
 func (d localDir) AdsTxt() string {
-	return "/web/ads.txt"
+	return d.fingerprintedOrPath("/web/ads.txt")
+}
+
+// fingerprintedOrPath returns the content-hashed path for path if it can be
+// resolved, falling back to path itself otherwise.
+func (d localDir) fingerprintedOrPath(path string) string {
+	if hashed, ok := d.Fingerprint(path); ok {
+		return hashed
+	}
+	return path
+}
+
+func (d localDir) Fingerprint(path string) (string, bool) {
+	name := strings.TrimPrefix(path, "/web/")
+	if containsDotDot(name) {
+		return "", false
+	}
+
+	content, err := os.ReadFile(filepath.Join(d.path, filepath.FromSlash(name)))
+	if err != nil {
+		return "", false
+	}
+
+	return "/web/" + fingerprint(name, content), true
+}
+
+// precompressedExtensions maps a Content-Encoding token to the file
+// extension of its precompressed sibling file.
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// PrecompressedLocalDir returns a resource provider that serves static
+// resources from a local directory, like LocalDir, but serves a
+// precompressed sibling file, eg app.wasm.br, instead of the original
+// whenever the request's Accept-Encoding header advertises a matching
+// encoding. Encodings are tried in the given order; supported values are
+// "br" and "gzip". Responses carry a strong ETag derived from the content of
+// the original, uncompressed file.
+func PrecompressedLocalDir(path string, encodings ...string) ResourceProvider {
+	return localDir{
+		Handler: withFingerprinting(&precompressedLocalDir{
+			path:      path,
+			encodings: encodings,
+			etags:     make(map[string]cachedETag),
+		}),
+		path: path,
+	}
+}
+
+type precompressedLocalDir struct {
+	path      string
+	encodings []string
+
+	mu    sync.Mutex
+	etags map[string]cachedETag
+}
+
+type cachedETag struct {
+	modTime time.Time
+	value   string
+}
+
+func (d *precompressedLocalDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/web/")
+	if containsDotDot(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	origPath := filepath.Join(d.path, filepath.FromSlash(name))
+
+	servedPath := origPath
+	encoding := ""
+
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, enc := range d.encodings {
+		ext, ok := precompressedExtensions[enc]
+		if !ok || !strings.Contains(acceptEncoding, enc) {
+			continue
+		}
+
+		candidate := origPath + ext
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			servedPath = candidate
+			encoding = enc
+			break
+		}
+	}
+
+	info, err := os.Stat(servedPath)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(servedPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Vary", "Accept-Encoding")
+	if etag, err := d.etag(origPath); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// etag returns a strong ETag derived from the sha256 hash of the file at
+// path, reusing the cached value as long as the file's mtime is unchanged.
+func (d *precompressedLocalDir) etag(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	if cached, ok := d.etags[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		d.mu.Unlock()
+		return cached.value, nil
+	}
+	d.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	value := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	d.mu.Lock()
+	d.etags[path] = cachedETag{modTime: info.ModTime(), value: value}
+	d.mu.Unlock()
+
+	return value, nil
 }
 
 // RemoteBucket returns a resource provider that provides resources from a
 // remote bucket such as Amazon S3 or Google Cloud Storage.
+//
+// The returned provider implements FingerprintedResourceProvider. If the
+// bucket serves a "/web/manifest.json" file mapping original static resource
+// names to their content-hashed equivalent, eg
+// {"app.wasm": "app.3a7c91f2.wasm"}, Fingerprint, AppWASM, RobotsTxt, and
+// AdsTxt resolve to the hashed URLs it lists; otherwise Fingerprint reports
+// false and the plain URLs are returned.
 func RemoteBucket(url string) ResourceProvider {
 	url = strings.TrimSuffix(url, "/")
 	url = strings.TrimSuffix(url, "/web")
 
-	return remoteBucket{
+	return &remoteBucket{
 		url: url,
 	}
 }
 
 type remoteBucket struct {
 	url string
+
+	manifestOnce sync.Once
+	manifest     map[string]string
+	manifestErr  error
 }
 
-func (b remoteBucket) AppResources() string {
+func (b *remoteBucket) AppResources() string {
 	return ""
 }
 
-func (b remoteBucket) StaticResources() string {
+func (b *remoteBucket) StaticResources() string {
 	return b.url
 }
 
-func (b remoteBucket) AppWASM() string {
-	return b.StaticResources() + "/web/app.wasm"
+func (b *remoteBucket) AppWASM() string {
+	return b.fingerprintedOrPath("/web/app.wasm")
+}
+
+func (b *remoteBucket) RobotsTxt() string {
+	return b.fingerprintedOrPath("/web/robots.txt")
+}
+
+func (b *remoteBucket) AdsTxt() string {
+	return b.fingerprintedOrPath("/web/ads.txt")
+}
+
+// fingerprintedOrPath returns the content-hashed URL for path if it can be
+// resolved from the bucket's manifest, falling back to the plain URL
+// otherwise.
+func (b *remoteBucket) fingerprintedOrPath(path string) string {
+	if hashed, ok := b.Fingerprint(path); ok {
+		return hashed
+	}
+	return b.StaticResources() + path
+}
+
+func (b *remoteBucket) Fingerprint(path string) (string, bool) {
+	manifest, err := b.loadManifest()
+	if err != nil {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path, "/web/")
+	hashed, ok := manifest[name]
+	if !ok {
+		return "", false
+	}
+
+	return b.StaticResources() + "/web/" + hashed, true
+}
+
+func (b *remoteBucket) loadManifest() (map[string]string, error) {
+	b.manifestOnce.Do(func() {
+		resp, err := http.Get(b.url + "/web/manifest.json")
+		if err != nil {
+			b.manifestErr = err
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b.manifestErr = fmt.Errorf("app: fetching fingerprint manifest: unexpected status %s", resp.Status)
+			return
+		}
+
+		b.manifest = make(map[string]string)
+		b.manifestErr = json.NewDecoder(resp.Body).Decode(&b.manifest)
+	})
+
+	return b.manifest, b.manifestErr
+}
+
+// buildTime is used as the Last-Modified time for resources served from an
+// embedded filesystem, since files packed with go:embed carry no
+// modification time of their own.
+var buildTime = time.Now()
+
+// EmbeddedFS returns a resource provider that serves static resources from an
+// embedded filesystem rooted at the given path. It is meant for applications
+// that pack their web directory into the binary with go:embed instead of
+// shipping it as a sibling directory on disk.
+func EmbeddedFS(f embed.FS, root string) ResourceProvider {
+	sub, err := fs.Sub(f, root)
+	if err != nil {
+		panic(err)
+	}
+
+	e := embeddedFS{fs: sub}
+	e.Handler = withFingerprinting(http.HandlerFunc(e.serveFile))
+	return e
+}
+
+type embeddedFS struct {
+	http.Handler
+	fs fs.FS
+}
+
+func (e embeddedFS) serveFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/web/")
+	if name == "" {
+		name = "."
+	}
+
+	f, err := e.fs.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "embedded file does not support seeking", http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, name, buildTime, content)
+}
+
+func (e embeddedFS) Fingerprint(path string) (string, bool) {
+	name := strings.TrimPrefix(path, "/web/")
+
+	content, err := fs.ReadFile(e.fs, name)
+	if err != nil {
+		return "", false
+	}
+
+	return "/web/" + fingerprint(name, content), true
+}
+
+func (e embeddedFS) AppResources() string {
+	return ""
+}
+
+func (e embeddedFS) StaticResources() string {
+	return ""
+}
+
+func (e embeddedFS) AppWASM() string {
+	return e.fingerprintedOrPath("/web/app.wasm")
 }
 
-func (b remoteBucket) RobotsTxt() string {
-	return b.StaticResources() + "/web/robots.txt"
+func (e embeddedFS) RobotsTxt() string {
+	return e.fingerprintedOrPath("/web/robots.txt")
 }
 
-func (b remoteBucket) AdsTxt() string {
-	return b.StaticResources() + "/web/ads.txt"
+func (e embeddedFS) AdsTxt() string {
+	return e.fingerprintedOrPath("/web/ads.txt")
+}
+
+// fingerprintedOrPath returns the content-hashed path for path if it can be
+// resolved, falling back to path itself otherwise.
+func (e embeddedFS) fingerprintedOrPath(path string) string {
+	if hashed, ok := e.Fingerprint(path); ok {
+		return hashed
+	}
+	return path
 }
 
 // GitHubPages returns a resource provider that provides resources from GitHub
@@ -149,3 +527,194 @@ func (g gitHubPages) RobotsTxt() string {
 func (g gitHubPages) AdsTxt() string {
 	return g.StaticResources() + "/web/ads.txt"
 }
+
+// MultiResourceProvider returns a resource provider that chains several
+// providers together. For a given request path, each provider is tried in
+// order and the next one is used whenever the previous one does not have the
+// requested resource. This lets an app serve most of its assets from one
+// provider, eg an EmbeddedFS baseline, while overriding individual files from
+// another, eg a LocalDir used during development.
+//
+// AppWASM, RobotsTxt, and AdsTxt resolve to the provider whose underlying
+// storage actually contains the corresponding file, probing lazily and
+// caching the result. Providers that cannot be probed, because they do not
+// implement http.Handler, are only used as a last resort: a probeable
+// provider that has the file always takes precedence over them.
+func MultiResourceProvider(providers ...ResourceProvider) ResourceProvider {
+	return &multiResourceProvider{
+		providers: providers,
+		resolved:  make(map[string]ResourceProvider),
+	}
+}
+
+type multiResourceProvider struct {
+	providers []ResourceProvider
+
+	mu       sync.Mutex
+	resolved map[string]ResourceProvider
+}
+
+// ServeHTTP tries each provider in order, falling through to the next one
+// whenever the previous one reports a 404. A non-last provider is probed
+// with a HEAD request rather than the real GET, so providers that serve
+// resources via http.ServeContent (LocalDir, PrecompressedLocalDir,
+// ProxiedRemoteBucket, EmbeddedFS) only ever write headers during the probe
+// instead of buffering the whole resource body, no matter its size. The
+// winning provider then serves the original request directly, so the
+// response is streamed to w rather than copied from a buffer.
+func (m *multiResourceProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for i, p := range m.providers {
+		h, ok := p.(http.Handler)
+		if !ok {
+			continue
+		}
+
+		if i == len(m.providers)-1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		head := r.Clone(r.Context())
+		head.Method = http.MethodHead
+
+		probe := newProbeWriter()
+		h.ServeHTTP(probe, head)
+		if probe.status == http.StatusNotFound {
+			continue
+		}
+
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (m *multiResourceProvider) AppResources() string {
+	if len(m.providers) == 0 {
+		return ""
+	}
+	return m.providers[0].AppResources()
+}
+
+func (m *multiResourceProvider) StaticResources() string {
+	if len(m.providers) == 0 {
+		return ""
+	}
+	return m.providers[0].StaticResources()
+}
+
+func (m *multiResourceProvider) AppWASM() string {
+	return m.resolve("app.wasm", ResourceProvider.AppWASM).AppWASM()
+}
+
+func (m *multiResourceProvider) RobotsTxt() string {
+	return m.resolve("robots.txt", ResourceProvider.RobotsTxt).RobotsTxt()
+}
+
+func (m *multiResourceProvider) AdsTxt() string {
+	return m.resolve("ads.txt", ResourceProvider.AdsTxt).AdsTxt()
+}
+
+// Fingerprint returns the content-hashed path for path from the first
+// chained provider that both implements FingerprintedResourceProvider and
+// can resolve it, so fingerprinting isn't silently lost when providers are
+// chained.
+func (m *multiResourceProvider) Fingerprint(path string) (string, bool) {
+	for _, p := range m.providers {
+		fp, ok := p.(FingerprintedResourceProvider)
+		if !ok {
+			continue
+		}
+
+		if hashed, ok := fp.Fingerprint(path); ok {
+			return hashed, true
+		}
+	}
+
+	return "", false
+}
+
+// resolve returns the provider that has the resource located at the path
+// returned by pathFor, probing lazily and caching the result under key.
+//
+// Providers that implement http.Handler are probed directly, and the first
+// one that actually has the resource wins regardless of position, since that
+// is the only verifiable signal available. Providers that cannot be probed,
+// eg RemoteBucket or GitHubPages, are used only as a last resort, in the
+// order they were given, since there is no way to confirm they have the
+// resource: probed providers like a LocalDir override always take precedence
+// over them when they can serve the resource themselves.
+func (m *multiResourceProvider) resolve(key string, pathFor func(ResourceProvider) string) ResourceProvider {
+	m.mu.Lock()
+	if p, ok := m.resolved[key]; ok {
+		m.mu.Unlock()
+		return p
+	}
+	m.mu.Unlock()
+
+	var unverified ResourceProvider
+	for _, p := range m.providers {
+		h, ok := p.(http.Handler)
+		if !ok {
+			if unverified == nil {
+				unverified = p
+			}
+			continue
+		}
+
+		req, err := http.NewRequest(http.MethodGet, pathFor(p), nil)
+		if err != nil {
+			continue
+		}
+
+		probe := newProbeWriter()
+		h.ServeHTTP(probe, req)
+		if probe.status != http.StatusNotFound {
+			m.cache(key, p)
+			return p
+		}
+	}
+
+	fallback := unverified
+	if fallback == nil && len(m.providers) > 0 {
+		fallback = m.providers[len(m.providers)-1]
+	}
+
+	m.cache(key, fallback)
+	return fallback
+}
+
+func (m *multiResourceProvider) cache(key string, p ResourceProvider) {
+	m.mu.Lock()
+	m.resolved[key] = p
+	m.mu.Unlock()
+}
+
+// probeWriter is a minimal http.ResponseWriter used to probe a handler
+// without writing to the real response unless its status turns out to not be
+// a 404.
+type probeWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newProbeWriter() *probeWriter {
+	return &probeWriter{header: make(http.Header)}
+}
+
+func (p *probeWriter) Header() http.Header {
+	return p.header
+}
+
+func (p *probeWriter) WriteHeader(status int) {
+	p.status = status
+}
+
+func (p *probeWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return p.body.Write(b)
+}
@@ -0,0 +1,519 @@
+// +build !wasm
+
+package app
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotModified is returned by a BucketFetcher to report that the resource
+// at the requested URL has not changed since the revalidation hints passed
+// in BucketFetchOptions.
+var ErrNotModified = errors.New("app: resource not modified")
+
+// BucketObject is a resource fetched from an upstream bucket by a
+// BucketFetcher.
+type BucketObject struct {
+	// The resource content. It is closed by the caller once read.
+	Body io.ReadCloser
+
+	// The MIME type of the resource, eg "application/wasm".
+	ContentType string
+
+	// The entity tag reported by the upstream bucket, if any.
+	ETag string
+
+	// The last modification time reported by the upstream bucket, if any.
+	LastModified time.Time
+}
+
+// BucketFetchOptions carries revalidation hints a BucketFetcher can use to
+// avoid re-downloading a resource that has not changed upstream.
+type BucketFetchOptions struct {
+	// IfNoneMatch is the ETag of the previously cached resource, if any.
+	IfNoneMatch string
+
+	// IfModifiedSince is the Last-Modified time of the previously cached
+	// resource, if any.
+	IfModifiedSince time.Time
+}
+
+// BucketFetcher fetches the resource located at the given upstream URL, eg
+// "s3://my-bucket/web/app.wasm". It returns a nil BucketObject and a nil
+// error when the resource does not exist, and ErrNotModified when opts
+// indicate the resource has not changed since it was last fetched.
+type BucketFetcher func(ctx context.Context, url string, opts BucketFetchOptions) (*BucketObject, error)
+
+// ProxyOption configures a ProxiedRemoteBucket.
+type ProxyOption func(*proxiedRemoteBucket)
+
+// WithCacheTTL sets how long a fetched resource is considered fresh before
+// the proxy revalidates it against the upstream bucket. It defaults to 5
+// minutes.
+func WithCacheTTL(ttl time.Duration) ProxyOption {
+	return func(b *proxiedRemoteBucket) {
+		b.ttl = ttl
+	}
+}
+
+// WithCacheSize sets the maximum number of resources kept in the in-memory
+// LRU cache, and, unless overridden with WithDiskCacheSize, the on-disk
+// cache too. It defaults to 100.
+func WithCacheSize(size int) ProxyOption {
+	return func(b *proxiedRemoteBucket) {
+		b.cacheSize = size
+		if !b.diskCacheSizeSet {
+			b.diskCacheSize = size
+		}
+	}
+}
+
+// WithCacheDir adds an on-disk cache tier, in addition to the in-memory one,
+// persisting fetched resources under dir across restarts. Like the
+// in-memory cache, it is bounded to WithCacheSize entries unless
+// WithDiskCacheSize says otherwise, evicting the least recently written
+// entries first.
+func WithCacheDir(dir string) ProxyOption {
+	return func(b *proxiedRemoteBucket) {
+		b.cacheDir = dir
+	}
+}
+
+// WithDiskCacheSize sets the maximum number of resources kept in the
+// on-disk cache tier enabled by WithCacheDir, independently of the
+// in-memory cache size. It defaults to the in-memory cache size.
+func WithDiskCacheSize(size int) ProxyOption {
+	return func(b *proxiedRemoteBucket) {
+		b.diskCacheSize = size
+		b.diskCacheSizeSet = true
+	}
+}
+
+// WithFetcher registers the fetcher used for upstream URLs with the given
+// scheme, eg "s3" or "gs". By default, "http" and "https" are supported via
+// the standard library's http.Client.
+func WithFetcher(scheme string, fetcher BucketFetcher) ProxyOption {
+	return func(b *proxiedRemoteBucket) {
+		b.fetchers[scheme] = fetcher
+	}
+}
+
+// ProxiedRemoteBucket returns a resource provider that fetches its static
+// resources from an upstream bucket server-side and caches them, instead of
+// redirecting the browser to the bucket directly like RemoteBucket does.
+// This allows serving resources from a private bucket, a bucket that
+// requires signed URLs, or keeping the app on a single origin.
+//
+// Fetched resources are cached in memory by path, along with their
+// ETag/Last-Modified so the proxy can revalidate with the upstream once the
+// cache TTL elapses, and optionally on disk with WithCacheDir. Because
+// resources are materialized locally, content fingerprinting applies to them
+// the same way it does to LocalDir. No Accept-Encoding negotiation is done:
+// resources are served as fetched, without looking for precompressed
+// variants.
+//
+// Support for upstream URL schemes other than "http" and "https", eg "s3" or
+// "gs", must be registered with WithFetcher.
+func ProxiedRemoteBucket(rawURL string, opts ...ProxyOption) ResourceProvider {
+	b := &proxiedRemoteBucket{
+		url:           strings.TrimSuffix(rawURL, "/"),
+		ttl:           5 * time.Minute,
+		cacheSize:     100,
+		diskCacheSize: 100,
+		fetchers:      make(map[string]BucketFetcher),
+		cache:         make(map[string]*list.Element),
+		order:         list.New(),
+	}
+	b.fetchers["http"] = httpBucketFetcher
+	b.fetchers["https"] = httpBucketFetcher
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	b.Handler = withFingerprinting(http.HandlerFunc(b.serveFile))
+	return b
+}
+
+type proxiedRemoteBucket struct {
+	http.Handler
+
+	url              string
+	ttl              time.Duration
+	cacheSize        int
+	cacheDir         string
+	diskCacheSize    int
+	diskCacheSizeSet bool
+	fetchers         map[string]BucketFetcher
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	order *list.List
+}
+
+// cacheEntry is a fetched resource kept in the in-memory LRU cache and,
+// optionally, the on-disk cache.
+type cacheEntry struct {
+	path         string
+	body         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	fetchedAt    time.Time
+}
+
+func (b *proxiedRemoteBucket) AppResources() string {
+	return ""
+}
+
+func (b *proxiedRemoteBucket) StaticResources() string {
+	return ""
+}
+
+func (b *proxiedRemoteBucket) AppWASM() string {
+	return b.fingerprintedOrPath("/web/app.wasm")
+}
+
+func (b *proxiedRemoteBucket) RobotsTxt() string {
+	return b.fingerprintedOrPath("/web/robots.txt")
+}
+
+func (b *proxiedRemoteBucket) AdsTxt() string {
+	return b.fingerprintedOrPath("/web/ads.txt")
+}
+
+// fingerprintedOrPath returns the content-hashed path for path if it can be
+// resolved, falling back to path itself otherwise.
+func (b *proxiedRemoteBucket) fingerprintedOrPath(path string) string {
+	if hashed, ok := b.Fingerprint(path); ok {
+		return hashed
+	}
+	return path
+}
+
+func (b *proxiedRemoteBucket) Fingerprint(path string) (string, bool) {
+	entry, err := b.get(context.Background(), path)
+	if err != nil || entry == nil {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path, "/web/")
+	return "/web/" + fingerprint(name, entry.body), true
+}
+
+func (b *proxiedRemoteBucket) serveFile(w http.ResponseWriter, r *http.Request) {
+	if containsDotDot(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	entry, err := b.get(r.Context(), r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if entry.etag != "" {
+		w.Header().Set("ETag", entry.etag)
+	}
+	if entry.contentType != "" {
+		w.Header().Set("Content-Type", entry.contentType)
+	}
+
+	http.ServeContent(w, r, r.URL.Path, entry.lastModified, bytes.NewReader(entry.body))
+}
+
+// get returns the cached or freshly fetched resource located at path,
+// revalidating against the upstream once the cache TTL elapses and falling
+// back to a stale cached copy if the upstream is unreachable.
+func (b *proxiedRemoteBucket) get(ctx context.Context, path string) (*cacheEntry, error) {
+	if containsDotDot(path) {
+		return nil, nil
+	}
+
+	mem := b.fromMemory(path)
+	if mem != nil && time.Since(mem.fetchedAt) < b.ttl {
+		return mem, nil
+	}
+
+	disk := b.loadFromDisk(path)
+	if disk != nil && time.Since(disk.fetchedAt) < b.ttl {
+		b.storeInMemory(path, disk)
+		return disk, nil
+	}
+
+	prev := mem
+	if prev == nil {
+		prev = disk
+	}
+
+	fetched, err := b.fetch(ctx, path, prev)
+	if err != nil {
+		if prev != nil {
+			return prev, nil
+		}
+		return nil, err
+	}
+	if fetched == nil {
+		return nil, nil
+	}
+
+	b.storeInMemory(path, fetched)
+	b.saveToDisk(fetched)
+	return fetched, nil
+}
+
+func (b *proxiedRemoteBucket) fromMemory(path string) *cacheEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	el, ok := b.cache[path]
+	if !ok {
+		return nil
+	}
+
+	b.order.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+func (b *proxiedRemoteBucket) storeInMemory(path string, entry *cacheEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if el, ok := b.cache[path]; ok {
+		el.Value = entry
+		b.order.MoveToFront(el)
+		return
+	}
+
+	b.cache[path] = b.order.PushFront(entry)
+
+	for b.order.Len() > b.cacheSize {
+		oldest := b.order.Back()
+		if oldest == nil {
+			break
+		}
+
+		b.order.Remove(oldest)
+		delete(b.cache, oldest.Value.(*cacheEntry).path)
+	}
+}
+
+// fetch retrieves path from the upstream bucket. If prev is non-nil, its
+// ETag/Last-Modified are sent as revalidation hints so the fetcher can
+// report ErrNotModified instead of re-downloading an unchanged resource.
+func (b *proxiedRemoteBucket) fetch(ctx context.Context, path string, prev *cacheEntry) (*cacheEntry, error) {
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, ok := b.fetchers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("app: no bucket fetcher registered for scheme %q", u.Scheme)
+	}
+
+	var opts BucketFetchOptions
+	if prev != nil {
+		opts.IfNoneMatch = prev.etag
+		opts.IfModifiedSince = prev.lastModified
+	}
+
+	obj, err := fetcher(ctx, b.url+path, opts)
+	if errors.Is(err, ErrNotModified) {
+		refreshed := *prev
+		refreshed.fetchedAt = time.Now()
+		return &refreshed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	defer obj.Body.Close()
+
+	body, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheEntry{
+		path:         path,
+		body:         body,
+		contentType:  obj.ContentType,
+		etag:         obj.ETag,
+		lastModified: obj.LastModified,
+		fetchedAt:    time.Now(),
+	}, nil
+}
+
+// diskCacheMeta is the JSON sidecar stored next to a cached resource's body
+// in the on-disk cache.
+type diskCacheMeta struct {
+	ContentType  string    `json:"contentType"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+func (b *proxiedRemoteBucket) diskPaths(path string) (body, meta string) {
+	sum := sha256.Sum256([]byte(path))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(b.cacheDir, name+".bin"), filepath.Join(b.cacheDir, name+".json")
+}
+
+func (b *proxiedRemoteBucket) loadFromDisk(path string) *cacheEntry {
+	if b.cacheDir == "" {
+		return nil
+	}
+
+	bodyPath, metaPath := b.diskPaths(path)
+
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil
+	}
+
+	var meta diskCacheMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return nil
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil
+	}
+
+	return &cacheEntry{
+		path:         path,
+		body:         body,
+		contentType:  meta.ContentType,
+		etag:         meta.ETag,
+		lastModified: meta.LastModified,
+		fetchedAt:    meta.FetchedAt,
+	}
+}
+
+func (b *proxiedRemoteBucket) saveToDisk(entry *cacheEntry) {
+	if b.cacheDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(b.cacheDir, 0o755); err != nil {
+		return
+	}
+
+	bodyPath, metaPath := b.diskPaths(entry.path)
+	if err := os.WriteFile(bodyPath, entry.body, 0o644); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(diskCacheMeta{
+		ContentType:  entry.contentType,
+		ETag:         entry.etag,
+		LastModified: entry.lastModified,
+		FetchedAt:    entry.fetchedAt,
+	})
+	if err != nil {
+		return
+	}
+
+	if err := os.WriteFile(metaPath, data, 0o644); err != nil {
+		return
+	}
+
+	b.evictFromDisk()
+}
+
+// evictFromDisk trims the on-disk cache down to diskCacheSize entries,
+// removing the least recently written ones first. It mirrors the in-memory
+// cache's count-based eviction, using each entry's sidecar mtime in place of
+// the LRU list since the disk tier has no in-process ordering of its own.
+func (b *proxiedRemoteBucket) evictFromDisk() {
+	if b.diskCacheSize <= 0 {
+		return
+	}
+
+	metas, err := filepath.Glob(filepath.Join(b.cacheDir, "*.json"))
+	if err != nil || len(metas) <= b.diskCacheSize {
+		return
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		iInfo, iErr := os.Stat(metas[i])
+		jInfo, jErr := os.Stat(metas[j])
+		if iErr != nil || jErr != nil {
+			return iErr == nil
+		}
+		return iInfo.ModTime().Before(jInfo.ModTime())
+	})
+
+	for _, metaPath := range metas[:len(metas)-b.diskCacheSize] {
+		name := strings.TrimSuffix(filepath.Base(metaPath), ".json")
+		os.Remove(metaPath)
+		os.Remove(filepath.Join(b.cacheDir, name+".bin"))
+	}
+}
+
+// httpBucketFetcher is the default BucketFetcher used for "http" and "https"
+// upstream URLs.
+func httpBucketFetcher(ctx context.Context, rawURL string, opts BucketFetchOptions) (*BucketObject, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", opts.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("app: fetching %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &BucketObject{
+		Body:         resp.Body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: lastModified,
+	}, nil
+}
@@ -0,0 +1,243 @@
+// +build !wasm
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//go:embed testdata/embedfs
+var embedFSTestData embed.FS
+
+func TestPrecompressedLocalDirServesPrecompressedSibling(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "app.wasm"), []byte("plain"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.wasm.br"), []byte("brotli"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := PrecompressedLocalDir(dir, "br", "gzip").(http.Handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/web/app.wasm", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got, want := rec.Body.String(), "brotli"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Content-Encoding"), "br"; got != want {
+		t.Errorf("Content-Encoding = %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Vary"), "Accept-Encoding"; got != want {
+		t.Errorf("Vary = %q, want %q", got, want)
+	}
+}
+
+func TestPrecompressedLocalDirRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+
+	outside := filepath.Join(filepath.Dir(dir), "secret_outside.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outside)
+
+	h := PrecompressedLocalDir(dir).(http.Handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/web/../secret_outside.txt", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec.Body.String() == "secret" {
+		t.Error("served a file outside of the configured directory")
+	}
+}
+
+func TestMultiResourceProviderFallsThroughToProbeableProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "robots.txt"), []byte("robots"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := RemoteBucket("https://cdn.example.com")
+	local := LocalDir(dir)
+	provider := MultiResourceProvider(remote, local)
+
+	if got, want := provider.RobotsTxt(), local.RobotsTxt(); got != want {
+		t.Errorf("RobotsTxt() = %q, want %q (local fallback)", got, want)
+	}
+	if got := provider.RobotsTxt(); got == remote.RobotsTxt() {
+		t.Errorf("RobotsTxt() = %q, should not resolve to the unverified remote provider", got)
+	}
+}
+
+func TestLocalDirAppWASMReturnsFingerprintedPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.wasm"), []byte("binary"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	local := LocalDir(dir)
+
+	got := local.AppWASM()
+	if got == "/web/app.wasm" {
+		t.Fatalf("AppWASM() = %q, want a content-hashed path", got)
+	}
+	if !fingerprintPattern.MatchString(got) {
+		t.Errorf("AppWASM() = %q, does not look content-hashed", got)
+	}
+}
+
+func TestMultiResourceProviderFingerprintDelegates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.wasm"), []byte("binary"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	local := LocalDir(dir)
+	provider := MultiResourceProvider(RemoteBucket("https://cdn.example.com"), local)
+
+	fp, ok := provider.(FingerprintedResourceProvider)
+	if !ok {
+		t.Fatal("MultiResourceProvider does not implement FingerprintedResourceProvider")
+	}
+
+	got, ok := fp.Fingerprint("/web/app.wasm")
+	if !ok {
+		t.Fatal("Fingerprint() = false, want true")
+	}
+
+	want, _ := local.(FingerprintedResourceProvider).Fingerprint("/web/app.wasm")
+	if got != want {
+		t.Errorf("Fingerprint() = %q, want %q", got, want)
+	}
+}
+
+func TestEmbeddedFSServesFileWithContentType(t *testing.T) {
+	e := EmbeddedFS(embedFSTestData, "testdata/embedfs").(http.Handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/web/style.css", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Body.String(), "body { color: red; }\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "text/css"; !strings.HasPrefix(got, want) {
+		t.Errorf("Content-Type = %q, want prefix %q", got, want)
+	}
+}
+
+func TestEmbeddedFSReturns404ForMissingPath(t *testing.T) {
+	e := EmbeddedFS(embedFSTestData, "testdata/embedfs").(http.Handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/web/does-not-exist.txt", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestProxiedRemoteBucketRevalidatesWithConditionalHeaders(t *testing.T) {
+	var fetches int32
+
+	provider := ProxiedRemoteBucket("http://bucket.internal", WithCacheTTL(0), WithFetcher("http", func(ctx context.Context, url string, opts BucketFetchOptions) (*BucketObject, error) {
+		atomic.AddInt32(&fetches, 1)
+		if opts.IfNoneMatch == `"v1"` {
+			return nil, ErrNotModified
+		}
+		return &BucketObject{
+			Body:         io.NopCloser(bytes.NewReader([]byte("content"))),
+			ContentType:  "text/plain",
+			ETag:         `"v1"`,
+			LastModified: time.Now(),
+		}, nil
+	}))
+
+	h := provider.(http.Handler)
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/web/app.wasm", nil))
+	if got, want := first.Body.String(), "content"; got != want {
+		t.Fatalf("first fetch body = %q, want %q", got, want)
+	}
+
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/web/app.wasm", nil))
+	if got, want := second.Body.String(), "content"; got != want {
+		t.Fatalf("second fetch body = %q, want %q", got, want)
+	}
+
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("fetcher called %d times, want 2 (initial fetch + one revalidation)", got)
+	}
+}
+
+func TestProxiedRemoteBucketEvictsDiskCache(t *testing.T) {
+	dir := t.TempDir()
+
+	provider := ProxiedRemoteBucket("http://bucket.internal",
+		WithCacheTTL(0),
+		WithCacheDir(dir),
+		WithDiskCacheSize(2),
+		WithFetcher("http", func(ctx context.Context, url string, opts BucketFetchOptions) (*BucketObject, error) {
+			return &BucketObject{
+				Body:        io.NopCloser(bytes.NewReader([]byte("content"))),
+				ContentType: "text/plain",
+			}, nil
+		}),
+	)
+
+	h := provider.(http.Handler)
+	for _, path := range []string{"/web/a.txt", "/web/b.txt", "/web/c.txt"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(matches), 2; got != want {
+		t.Errorf("on-disk cache has %d entries, want %d", got, want)
+	}
+}
+
+func TestProxiedRemoteBucketRejectsPathTraversal(t *testing.T) {
+	provider := ProxiedRemoteBucket("http://bucket.internal", WithFetcher("http", func(ctx context.Context, url string, opts BucketFetchOptions) (*BucketObject, error) {
+		t.Fatalf("fetcher should not be called for a path-traversal request, got url %q", url)
+		return nil, nil
+	}))
+
+	h := provider.(http.Handler)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/web/../other-prefix/object", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}